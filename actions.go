@@ -1,162 +1,419 @@
 package main
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"regexp"
+	"strconv"
 	"strings"
+	"syscall"
 )
 
-// remuxFile contains the core logic for a single file, returns the "final" path on success/skip
-func remuxFile(inputFile string, cfg *Config) (string, error) {
-	fmt.Printf("Processing: %s\n", inputFile)
-	// A. Inspect file
-	cmd := exec.Command("mkvmerge", "-J", inputFile)
-	output, err := cmd.Output()
-	if err != nil {
-		return "", fmt.Errorf("mkvmerge inspection failed: %w", err)
+// buildRemuxPlan inspects info against policy and decides what, if
+// anything, needs to change: tracks to keep/drop, language/default-flag/
+// rename changes, the resolved output path (per outputMode), and
+// whether the original should survive. It performs no I/O.
+func buildRemuxPlan(inputFile string, info *MkvInfo, policy *Policy, outputMode OutputMode, sourceRoot string) *RemuxPlan {
+	plan := &RemuxPlan{
+		InputFile:          inputFile,
+		LanguageChanges:    make(map[int]string),
+		DefaultFlagChanges: make(map[int]bool),
+		RenameTracks:       make(map[int]string),
 	}
 
-	var info MkvInfo
-	if err := json.Unmarshal(output, &info); err != nil {
-		return "", fmt.Errorf("json parsing failed: %w", err)
-	}
+	videoNameRe := compileNameMatch(policy.Video.NameMatch)
+	audioNameRe := compileNameMatch(policy.Audio.NameMatch)
+	subsNameRe := compileNameMatch(policy.Subtitles.NameMatch)
+	videoTarget := videoTargetLanguage(policy.Video)
 
-	// Check requirements:
-	// 1. Video must be cfg.VideoLanguage
-	// 2. ONLY 'eng' subtitles should be kept -> ONLY cfg.SubtitleLanguages
 	hasVideo := false
 	needsFix := false
-
-	// Helper to check if lang is in list
-	isInList := func(lang string, list []string) bool {
-		for _, l := range list {
-			if l == lang {
-				return true
-			}
-		}
-		return false
-	}
+	var keptAudio []Track
 
 	for _, track := range info.Tracks {
-		if track.Type == "video" {
+		switch track.Type {
+		case "video":
 			hasVideo = true
-			if track.Properties.Language != cfg.VideoLanguage {
+			decision := evaluateTrack(track, policy.Video, videoNameRe)
+			if videoTarget != "" && !languagesEqual(trackLanguage(track), videoTarget) {
 				needsFix = true
+				plan.LanguageChanges[track.ID] = videoTarget
 			}
-		}
-		if track.Type == "audio" {
-			// If audio is NOT in the allowed list, we need to fix (remux to remove it)
-			// OR if audio IS in the list but not marked default when it should be
-			if !isInList(track.Properties.Language, cfg.AudioLanguages) {
+			plan.TrackDecisions = append(plan.TrackDecisions, decision)
+		case "audio":
+			decision := evaluateTrack(track, policy.Audio, audioNameRe)
+			if !decision.Kept {
 				needsFix = true
-			} else {
-				// It IS in the list. Check default flag compliance.
-				// If this track's language is the target DefaultAudio, it SHOULD be default.
-				// Otherwise, it SHOULD NOT be default.
-				shouldBeDefault := track.Properties.Language == cfg.DefaultAudio
-				if track.Properties.DefaultTrack != shouldBeDefault {
-					needsFix = true
-				}
+				plan.DroppedAudioTracks = append(plan.DroppedAudioTracks, track.ID)
+				plan.TrackDecisions = append(plan.TrackDecisions, decision)
+				continue
 			}
-		}
-		if track.Type == "subtitles" {
-			if !isInList(track.Properties.Language, cfg.SubtitleLanguages) {
+			plan.KeptAudioTracks = append(plan.KeptAudioTracks, track.ID)
+			keptAudio = append(keptAudio, track)
+
+			if newName := renderTrackName(policy.Audio, track); newName != track.Properties.Name {
 				needsFix = true
+				plan.RenameTracks[track.ID] = newName
+				decision.NewName = newName
 			}
+			plan.TrackDecisions = append(plan.TrackDecisions, decision)
+		case "subtitles":
+			decision := evaluateTrack(track, policy.Subtitles, subsNameRe)
+			if !decision.Kept {
+				needsFix = true
+				plan.DroppedSubtitleTracks = append(plan.DroppedSubtitleTracks, track.ID)
+				plan.TrackDecisions = append(plan.TrackDecisions, decision)
+				continue
+			}
+			plan.KeptSubtitleTracks = append(plan.KeptSubtitleTracks, track.ID)
+
+			if newName := renderTrackName(policy.Subtitles, track); newName != track.Properties.Name {
+				needsFix = true
+				plan.RenameTracks[track.ID] = newName
+				decision.NewName = newName
+			}
+			plan.TrackDecisions = append(plan.TrackDecisions, decision)
 		}
 	}
 
-	if hasVideo && !needsFix {
-		fmt.Printf("Skipping %s: Already meets requirements (Video=%s, Subs=%v)\n", inputFile, cfg.VideoLanguage, cfg.SubtitleLanguages)
-		return inputFile, nil
+	if defaultID := chooseDefaultAudio(keptAudio, policy.Audio); defaultID != -1 {
+		plan.DefaultAudioTrackID = &defaultID
+	}
+	for _, track := range keptAudio {
+		shouldBeDefault := plan.DefaultAudioTrackID != nil && *plan.DefaultAudioTrackID == track.ID
+		if track.Properties.DefaultTrack != shouldBeDefault {
+			needsFix = true
+			plan.DefaultFlagChanges[track.ID] = shouldBeDefault
+			for i := range plan.TrackDecisions {
+				if plan.TrackDecisions[i].TrackID == track.ID && plan.TrackDecisions[i].Type == "audio" {
+					flag := shouldBeDefault
+					plan.TrackDecisions[i].NewDefault = &flag
+				}
+			}
+		}
 	}
 
-	// B. Build output filename
-	ext := filepath.Ext(inputFile)
-	baseName := strings.TrimSuffix(inputFile, ext)
-	outputFile := fmt.Sprintf("%s-remux%s", baseName, ext)
+	// Mirrors the original compliance check: a file with no video track
+	// at all is never considered compliant.
+	plan.NeedsRemux = !hasVideo || needsFix
+	if !plan.NeedsRemux {
+		plan.OutputFile = inputFile
+		plan.KeepOriginal = true
+		return plan
+	}
 
-	args := []string{"-o", outputFile}
+	plan.OutputFile, plan.KeepOriginal = resolveOutputPath(inputFile, outputMode, sourceRoot)
+	return plan
+}
 
-	// C. Track Logic
-	var keepAudioIds []string
-	var keepSubtitleIds []string
+// resolveOutputPath computes the final output path for inputFile under
+// outputMode, and whether the original file should be left in place
+// afterward.
+func resolveOutputPath(inputFile string, outputMode OutputMode, sourceRoot string) (outputFile string, keepOriginal bool) {
+	ext := filepath.Ext(inputFile)
+	baseName := strings.TrimSuffix(inputFile, ext)
 
-	for _, track := range info.Tracks {
-		// Filter Audio
-		if track.Type == "audio" {
-			if isInList(track.Properties.Language, cfg.AudioLanguages) {
-				keepAudioIds = append(keepAudioIds, fmt.Sprintf("%d", track.ID))
-			}
+	switch outputMode.Mode {
+	case OutputModeSidecar:
+		return fmt.Sprintf("%s.remux%s", baseName, ext), true
+	case OutputModeDirectory:
+		rel, err := filepath.Rel(sourceRoot, inputFile)
+		if err != nil || strings.HasPrefix(rel, "..") {
+			rel = filepath.Base(inputFile)
 		}
-		// Filter Subtitles
-		if track.Type == "subtitles" {
-			if isInList(track.Properties.Language, cfg.SubtitleLanguages) {
-				keepSubtitleIds = append(keepSubtitleIds, fmt.Sprintf("%d", track.ID))
-			}
+		return filepath.Join(outputMode.DirectoryRoot, rel), true
+	default: // OutputModeReplace
+		return fmt.Sprintf("%s-remux%s", baseName, ext), false
+	}
+}
+
+// parseOutputMode parses the --output-mode flag value: "replace",
+// "sidecar", or "directory:<path>".
+func parseOutputMode(raw string) (OutputMode, error) {
+	switch {
+	case raw == "" || raw == OutputModeReplace:
+		return OutputMode{Mode: OutputModeReplace}, nil
+	case raw == OutputModeSidecar:
+		return OutputMode{Mode: OutputModeSidecar}, nil
+	case strings.HasPrefix(raw, OutputModeDirectory+":"):
+		dir := strings.TrimPrefix(raw, OutputModeDirectory+":")
+		if dir == "" {
+			return OutputMode{}, fmt.Errorf("directory output mode requires a path, e.g. directory:/path/to/out")
 		}
+		return OutputMode{Mode: OutputModeDirectory, DirectoryRoot: dir}, nil
+	default:
+		return OutputMode{}, fmt.Errorf("unknown --output-mode %q (expected replace, sidecar, or directory:<path>)", raw)
+	}
+}
+
+// executeRemuxPlan runs mkvmerge into a crash-safe temp file alongside
+// plan.OutputFile's destination, fsyncs and sanity-checks it, then
+// atomically renames it into place. The temp file lives next to the
+// destination rather than the input so the final os.Rename is always
+// same-filesystem, even when --output-mode directory points at a
+// different mount than the source tree. The original is removed
+// afterward unless plan.KeepOriginal is set. progress (may be nil) is
+// called with mkvmerge's own completion percentage as it runs.
+func executeRemuxPlan(plan *RemuxPlan, info *MkvInfo, policy *Policy, progress func(percent int)) error {
+	outDir := filepath.Dir(plan.OutputFile)
+	if err := os.MkdirAll(outDir, 0755); err != nil {
+		return fmt.Errorf("could not create output directory: %w", err)
 	}
 
-	// Handle Audio: keep explicit list
-	if len(keepAudioIds) > 0 {
-		args = append(args, "--audio-tracks", strings.Join(keepAudioIds, ","))
+	tmpOut, err := tempOutputName(outDir)
+	if err != nil {
+		return fmt.Errorf("could not allocate temp output file: %w", err)
+	}
+
+	args := []string{"-o", tmpOut, "--gui-mode"}
+
+	if len(plan.KeptAudioTracks) > 0 {
+		args = append(args, "--audio-tracks", joinTrackIDs(plan.KeptAudioTracks))
 	} else {
-		// If no audio matches config, mkvmerge defaults to keeping all? No, we should probably keep none?
-		// Be careful here. If user config is wrong, they lose all audio.
-		// Let's assume strict compliance.
 		args = append(args, "--no-audio")
 	}
 
-	// Handle Subtitles: keep explicit list
-	if len(keepSubtitleIds) > 0 {
-		args = append(args, "--subtitle-tracks", strings.Join(keepSubtitleIds, ","))
+	if len(plan.KeptSubtitleTracks) > 0 {
+		args = append(args, "--subtitle-tracks", joinTrackIDs(plan.KeptSubtitleTracks))
 	} else {
 		args = append(args, "--no-subtitles")
 	}
 
+	videoTarget := videoTargetLanguage(policy.Video)
+
 	for _, track := range info.Tracks {
-		// Set Video Language
-		if track.Type == "video" {
-			args = append(args, "--language", fmt.Sprintf("%d:%s", track.ID, cfg.VideoLanguage))
+		if track.Type == "video" && videoTarget != "" {
+			args = append(args, "--language", fmt.Sprintf("%d:%s", track.ID, videoTarget))
 		}
 
-		// Handle Audio Defaults
-		if track.Type == "audio" {
-			// Only mess with flags if we are keeping this track
-			if isInList(track.Properties.Language, cfg.AudioLanguages) {
-				if track.Properties.Language == cfg.DefaultAudio {
-					args = append(args, "--default-track", fmt.Sprintf("%d:yes", track.ID))
-				} else {
-					args = append(args, "--default-track", fmt.Sprintf("%d:no", track.ID))
-				}
+		if track.Type == "audio" && containsTrackID(plan.KeptAudioTracks, track.ID) {
+			flag := "no"
+			if plan.DefaultAudioTrackID != nil && *plan.DefaultAudioTrackID == track.ID {
+				flag = "yes"
 			}
+			args = append(args, "--default-track", fmt.Sprintf("%d:%s", track.ID, flag))
+		}
+
+		if newName, ok := plan.RenameTracks[track.ID]; ok {
+			args = append(args, "--track-name", fmt.Sprintf("%d:%s", track.ID, newName))
 		}
 	}
 
-	args = append(args, inputFile)
+	args = append(args, plan.InputFile)
 
-	// D. Execute Remux
 	remuxCmd := exec.Command("mkvmerge", args...)
-	// Connect stdout/stderr if you want to see mkvmerge progress bars,
-	// otherwise keep it silent or log to file.
-	// remuxCmd.Stdout = os.Stdout
+	stdout, err := remuxCmd.StdoutPipe()
+	if err != nil {
+		return fmt.Errorf("remux command failed: %w", err)
+	}
+	if err := remuxCmd.Start(); err != nil {
+		return fmt.Errorf("remux command failed: %w", err)
+	}
+
+	watchMkvmergeProgress(stdout, progress)
+
+	if err := remuxCmd.Wait(); err != nil {
+		os.Remove(tmpOut)
+		return fmt.Errorf("remux command failed: %w", err)
+	}
+
+	if err := fsyncFile(tmpOut); err != nil {
+		os.Remove(tmpOut)
+		return fmt.Errorf("could not fsync remuxed output: %w", err)
+	}
+
+	if err := sanityCheckOutput(tmpOut); err != nil {
+		os.Remove(tmpOut)
+		return fmt.Errorf("remuxed output failed sanity check: %w", err)
+	}
+
+	if err := os.Rename(tmpOut, plan.OutputFile); err != nil {
+		os.Remove(tmpOut)
+		return fmt.Errorf("could not move remuxed output into place: %w", err)
+	}
+
+	if !plan.KeepOriginal {
+		if err := os.Remove(plan.InputFile); err != nil {
+			return fmt.Errorf("could not delete original file: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// tempOutputName returns a crash-safe temp filename in dir, following the
+// .mkvfixer-<pid>-<rand>.mkv.tmp pattern so a stale-temp-file sweep can
+// identify and reclaim it on a later run.
+func tempOutputName(dir string) (string, error) {
+	randBytes := make([]byte, 4)
+	if _, err := rand.Read(randBytes); err != nil {
+		return "", err
+	}
+	name := fmt.Sprintf(".mkvfixer-%d-%s.mkv.tmp", os.Getpid(), hex.EncodeToString(randBytes))
+	return filepath.Join(dir, name), nil
+}
+
+// fsyncFile flushes path's contents to stable storage.
+func fsyncFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return f.Sync()
+}
+
+// sanityCheckOutput confirms mkvmerge can still read back the remuxed
+// file and that it has at least one track, catching truncated or
+// corrupted output before it's swapped into place.
+func sanityCheckOutput(path string) error {
+	out, err := exec.Command("mkvmerge", "-J", path).Output()
+	if err != nil {
+		return err
+	}
+
+	var info MkvInfo
+	if err := json.Unmarshal(out, &info); err != nil {
+		return err
+	}
+	if len(info.Tracks) == 0 {
+		return fmt.Errorf("no tracks found in remuxed output")
+	}
+	return nil
+}
+
+// tempFilePattern matches the crash-safe temp files executeRemuxPlan
+// writes mid-remux.
+var tempFilePattern = regexp.MustCompile(`^\.mkvfixer-(\d+)-[0-9a-f]+\.mkv\.tmp$`)
+
+// sweepStaleTempFiles removes .mkvfixer-*.tmp leftovers from a previous
+// run that crashed mid-remux, as long as the PID embedded in the
+// filename is no longer alive. It sweeps root (the source tree,
+// recursively only when recursive is set, matching the scan's own
+// traversal) and, when directoryRoot is non-empty and distinct, that
+// tree too — --output-mode directory:<path> writes its crash-safe temp
+// files alongside the output there instead of under root, so a crash in
+// that mode leaves stale temp files outside root entirely.
+func sweepStaleTempFiles(root, directoryRoot string, recursive bool) error {
+	if err := sweepStaleTempFilesIn(root, recursive); err != nil {
+		return err
+	}
+
+	if directoryRoot == "" || directoryRoot == root {
+		return nil
+	}
+	if _, err := os.Stat(directoryRoot); err != nil {
+		// Nothing written there yet (e.g. first run); nothing to sweep.
+		return nil
+	}
+	return sweepStaleTempFilesIn(directoryRoot, true)
+}
+
+// sweepStaleTempFilesIn does the actual walk-and-remove for a single
+// root, as described on sweepStaleTempFiles.
+func sweepStaleTempFilesIn(root string, recursive bool) error {
+	return filepath.WalkDir(root, func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if !recursive && path != root {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		m := tempFilePattern.FindStringSubmatch(d.Name())
+		if m == nil {
+			return nil
+		}
+
+		if pid, perr := strconv.Atoi(m[1]); perr == nil && processAlive(pid) {
+			return nil
+		}
+
+		if rerr := os.Remove(path); rerr != nil {
+			fmt.Printf("Warning: could not remove stale temp file %s: %v\n", path, rerr)
+		} else {
+			fmt.Printf("Removed stale temp file: %s\n", path)
+		}
+		return nil
+	})
+}
+
+// processAlive reports whether pid refers to a currently running process.
+func processAlive(pid int) bool {
+	proc, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	return proc.Signal(syscall.Signal(0)) == nil
+}
+
+// joinTrackIDs renders track IDs as the comma-separated list mkvmerge
+// expects for flags like --audio-tracks.
+func joinTrackIDs(ids []int) string {
+	parts := make([]string, len(ids))
+	for i, id := range ids {
+		parts[i] = fmt.Sprintf("%d", id)
+	}
+	return strings.Join(parts, ",")
+}
+
+// containsTrackID reports whether id appears in ids.
+func containsTrackID(ids []int, id int) bool {
+	for _, x := range ids {
+		if x == id {
+			return true
+		}
+	}
+	return false
+}
+
+// remuxFile inspects inputFile, computes a RemuxPlan against cfg and
+// outputMode, and — unless dryRun is set — executes it, reporting live
+// mkvmerge progress through progress (may be nil). It returns the plan
+// describing what was done (or would be done) and any error encountered.
+// Status lines go through logger rather than fmt.Printf directly so a
+// concurrent progress UI on stdout isn't corrupted.
+func remuxFile(inputFile string, cfg *Config, dryRun bool, outputMode OutputMode, sourceRoot string, logger Logger, progress func(percent int)) (*RemuxPlan, error) {
+	logger.Printf("Processing: %s\n", inputFile)
+
+	cmd := exec.Command("mkvmerge", "-J", inputFile)
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("mkvmerge inspection failed: %w", err)
+	}
+
+	var info MkvInfo
+	if err := json.Unmarshal(output, &info); err != nil {
+		return nil, fmt.Errorf("json parsing failed: %w", err)
+	}
+
+	policy := resolvePolicy(cfg)
+	plan := buildRemuxPlan(inputFile, &info, policy, outputMode, sourceRoot)
+
+	if !plan.NeedsRemux {
+		logger.Printf("Skipping %s: Already meets requirements (Video=%s, Subs=%v)\n", inputFile, videoTargetLanguage(policy.Video), policy.Subtitles.Languages)
+		return plan, nil
+	}
 
-	if err := remuxCmd.Run(); err != nil {
-		// Clean up partial file if failed
-		os.Remove(outputFile)
-		return "", fmt.Errorf("remux command failed: %w", err)
+	if dryRun {
+		logger.Printf("Dry-run: %s would be remuxed -> %s\n", inputFile, plan.OutputFile)
+		return plan, nil
 	}
 
-	// E. Remove old file
-	if err := os.Remove(inputFile); err != nil {
-		return "", fmt.Errorf("could not delete original file: %w", err)
+	if err := executeRemuxPlan(plan, &info, policy, progress); err != nil {
+		return plan, err
 	}
 
-	fmt.Printf("Success: %s -> %s\n", inputFile, outputFile)
-	return outputFile, nil
+	logger.Printf("Success: %s -> %s\n", inputFile, plan.OutputFile)
+	return plan, nil
 }
 
 func loadConfig(path string) (*Config, error) {
@@ -1,31 +1,69 @@
 package main
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"fmt"
+	"io"
 	"os"
-	"path/filepath"
 	"sync"
 )
 
-// Cache handles keeping track of processed files
+// cacheFingerprintChunk is how much of the head/tail of a file we hash to
+// build its fingerprint.
+const cacheFingerprintChunk = 64 * 1024
+
+// currentCacheVersion is the on-disk cache format version this build
+// writes. Bump this whenever the entry schema changes.
+const currentCacheVersion = 2
+
+// CacheEntry records what we last knew about a file: whether it was
+// compliant (or already remuxed to compliance).
+type CacheEntry struct {
+	Compliant bool `json:"compliant"`
+}
+
+// Cache handles keeping track of processed files using a content-addressed
+// fingerprint rather than path/mtime, so remuxes aren't repeated when a
+// file is merely touched, moved, or copied between drives (all of which
+// change mtime but not content).
 type Cache struct {
-	mu    sync.RWMutex
-	Items map[string]int64 `json:"items"` // Path -> ModTime
-	path  string
+	mu      sync.RWMutex
+	Version int                   `json:"version"`
+	Entries map[string]CacheEntry `json:"entries"`
+	path    string
 }
 
-// NewCache loads or creates a new cache at the specific path
+// NewCache loads or creates a new cache at the specified path. It
+// transparently upgrades the legacy path->mtime (v1) cache format (a
+// bare map with no "version"/"entries" wrapper): any v1 entry whose
+// file still exists with its recorded mtime unchanged is verifiably the
+// same content that was last marked compliant, so it's fingerprinted
+// and carried forward as a v2 entry without re-running mkvmerge.
+// Entries that fail that check (file gone, or touched since) are
+// dropped and simply get re-evaluated on the next pass.
 func NewCache(path string) (*Cache, error) {
 	c := &Cache{
-		Items: make(map[string]int64),
-		path:  path,
+		Version: currentCacheVersion,
+		Entries: make(map[string]CacheEntry),
+		path:    path,
 	}
 
 	data, err := os.ReadFile(path)
 	if err == nil {
-		if err := json.Unmarshal(data, &c.Items); err != nil {
-			// If corrupted, just return empty cache, or maybe error?
-			// Ignoring error is safer to recover.
+		// Probe the version field before decoding into c: c.Version
+		// already defaults to currentCacheVersion, so unmarshaling a
+		// versionless (v1) blob straight into c would leave it looking
+		// falsely up to date instead of triggering migration.
+		var probe struct {
+			Version int `json:"version"`
+		}
+		if perr := json.Unmarshal(data, &probe); perr != nil || probe.Version != currentCacheVersion {
+			migrateLegacyCache(data, c)
+		} else if uerr := json.Unmarshal(data, c); uerr != nil {
+			c.Version = currentCacheVersion
+			c.Entries = make(map[string]CacheEntry)
 		}
 	} else if os.IsNotExist(err) {
 		// Fine, new cache
@@ -36,55 +74,113 @@ func NewCache(path string) (*Cache, error) {
 	return c, nil
 }
 
-// Check returns true if the file is cached and the modtime matches.
-func (c *Cache) Check(cacheKey, filePath string) (bool, error) {
-	absPath, err := filepath.Abs(filePath)
+// migrateLegacyCache upgrades a v1 path->mtime cache (passed in as raw
+// JSON, since its "version" field is missing or not currentCacheVersion)
+// into v2 content fingerprints in place on c.
+func migrateLegacyCache(data []byte, c *Cache) {
+	var legacy map[string]int64
+	if json.Unmarshal(data, &legacy) != nil {
+		// Genuinely corrupt; nothing to migrate.
+		return
+	}
+
+	for path, mtime := range legacy {
+		stat, err := os.Stat(path)
+		if err != nil || stat.ModTime().Unix() != mtime {
+			continue
+		}
+
+		key, err := fingerprint(path)
+		if err != nil {
+			continue
+		}
+		c.Entries[key] = CacheEntry{Compliant: true}
+	}
+}
+
+// fingerprint computes a content-addressed cache key from the file's size
+// plus a SHA-256 of its first and last 64KiB. Unlike mtime, this survives
+// renames, moves, and copies across drives or NAS remounts.
+func fingerprint(filePath string) (string, error) {
+	f, err := os.Open(filePath)
 	if err != nil {
-		return false, err
+		return "", err
 	}
+	defer f.Close()
 
-	stat, err := os.Stat(absPath)
+	stat, err := f.Stat()
+	if err != nil {
+		return "", err
+	}
+	size := stat.Size()
+
+	head, err := readChunk(f, 0)
+	if err != nil {
+		return "", err
+	}
+
+	tailOffset := size - cacheFingerprintChunk
+	if tailOffset < 0 {
+		tailOffset = 0
+	}
+	tail, err := readChunk(f, tailOffset)
+	if err != nil {
+		return "", err
+	}
+
+	headSum := sha256.Sum256(head)
+	tailSum := sha256.Sum256(tail)
+
+	return fmt.Sprintf("%d-%s-%s", size, hex.EncodeToString(headSum[:]), hex.EncodeToString(tailSum[:])), nil
+}
+
+// readChunk reads up to cacheFingerprintChunk bytes starting at offset,
+// returning a short read at EOF rather than an error.
+func readChunk(f *os.File, offset int64) ([]byte, error) {
+	buf := make([]byte, cacheFingerprintChunk)
+	n, err := f.ReadAt(buf, offset)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	return buf[:n], nil
+}
+
+// Check returns true if the file's content fingerprint is cached and was
+// last recorded as compliant.
+func (c *Cache) Check(filePath string) (bool, error) {
+	key, err := fingerprint(filePath)
 	if err != nil {
 		return false, err
 	}
 
 	c.mu.RLock()
-	cachedTime, ok := c.Items[cacheKey]
+	entry, ok := c.Entries[key]
 	c.mu.RUnlock()
 
 	if !ok {
 		return false, nil
 	}
 
-	if stat.ModTime().Unix() == cachedTime {
-		return true, nil
-	}
-
-	return false, nil
+	return entry.Compliant, nil
 }
 
-// Update adds or updates a file in the cache.
-func (c *Cache) Update(cacheKey, filePath string) error {
-	absPath, err := filepath.Abs(filePath)
-	if err != nil {
-		return err
-	}
-
-	stat, err := os.Stat(absPath)
+// Update records the file's current content fingerprint as compliant.
+func (c *Cache) Update(filePath string) error {
+	key, err := fingerprint(filePath)
 	if err != nil {
 		return err
 	}
 
 	c.mu.Lock()
-	c.Items[cacheKey] = stat.ModTime().Unix()
+	c.Entries[key] = CacheEntry{Compliant: true}
 	c.mu.Unlock()
 	return nil
 }
 
-// Save writes the cache to disk
+// Save writes the cache to disk in the current (v2) format.
 func (c *Cache) Save() error {
 	c.mu.RLock()
-	data, err := json.MarshalIndent(c.Items, "", "  ")
+	data, err := json.MarshalIndent(c, "", "  ")
 	c.mu.RUnlock()
 	if err != nil {
 		return err
@@ -2,16 +2,29 @@ package main
 
 import (
 	"fmt"
+	"io/fs"
 	"os"
 	"os/signal"
 	"path/filepath"
 	"strings"
 	"sync"
 	"syscall"
+	"time"
 
+	"github.com/fsnotify/fsnotify"
 	"github.com/urfave/cli/v2"
 )
 
+// watchSettleDelay is how long a newly-seen file's size must remain
+// unchanged before we consider it fully written and enqueue it. This
+// guards against downloaders/torrent clients that create the file
+// before they've finished moving/writing data into it.
+const watchSettleDelay = 3 * time.Second
+
+// watchStatInterval is the polling cadence used while waiting for a
+// file's size to settle.
+const watchStatInterval = 1 * time.Second
+
 func main() {
 	app := &cli.App{
 		Name:  "mkvfixer",
@@ -33,6 +46,32 @@ func main() {
 				Usage:   "Number of concurrent workers",
 				Value:   4,
 			},
+			&cli.BoolFlag{
+				Name:    "watch",
+				Aliases: []string{"w"},
+				Usage:   "Keep running after the initial scan and remux new .mkv files as they appear",
+			},
+			&cli.BoolFlag{
+				Name:  "dry-run",
+				Usage: "Compute and log what would be done without invoking mkvmerge or deleting originals",
+			},
+			&cli.StringFlag{
+				Name:  "report",
+				Usage: "Write an NDJSON report of every file processed to the given path",
+			},
+			&cli.StringFlag{
+				Name:  "output-mode",
+				Usage: "How to write remuxed output: replace (default), sidecar, or directory:<path>",
+				Value: OutputModeReplace,
+			},
+			&cli.BoolFlag{
+				Name:  "no-progress",
+				Usage: "Disable the live progress bars and fall back to plain logging",
+			},
+			&cli.IntFlag{
+				Name:  "walk-workers",
+				Usage: "Number of concurrent goroutines used to enumerate directories (0 = runtime.NumCPU())",
+			},
 		},
 		Action: func(c *cli.Context) error {
 			// 1. Load Config
@@ -51,6 +90,17 @@ func main() {
 			}
 
 			isRecursive := c.Bool("recursive")
+			isWatch := c.Bool("watch")
+
+			outputMode, err := parseOutputMode(c.String("output-mode"))
+			if err != nil {
+				return err
+			}
+
+			if err := sweepStaleTempFiles(targetDir, outputMode.DirectoryRoot, isRecursive); err != nil {
+				fmt.Printf("Warning: stale temp file sweep failed: %v\n", err)
+			}
+
 			fmt.Printf("Scanning directory: %s (Recursive: %v)\n", targetDir, isRecursive)
 			// 3. Load Cache
 			// Where to store it? Ideally in current dir or home.
@@ -64,7 +114,7 @@ func main() {
 				// Create new empty cache
 				fileCache, _ = NewCache(cachePath)
 			} else {
-				fmt.Printf("Loaded cache with %d items\n", len(fileCache.Items))
+				fmt.Printf("Loaded cache with %d items\n", len(fileCache.Entries))
 			}
 
 			// Ensure we save the cache on exit (even on error/panic)
@@ -87,26 +137,95 @@ func main() {
 			jobs := make(chan string, numWorkers*2)
 			var wg sync.WaitGroup
 
+			isDryRun := c.Bool("dry-run")
+
+			reportPath := c.String("report")
+			var report *reportWriter
+			if reportPath != "" {
+				report, err = newReportWriter(reportPath)
+				if err != nil {
+					return fmt.Errorf("failed to open report file %s: %v", reportPath, err)
+				}
+				defer report.Close()
+			}
+
+			// Progress UI / logging: when bars are active, status lines
+			// route to stderr via logger so they don't corrupt the
+			// bars' redraws on stdout.
+			var logger Logger = stdLogger{}
+			var ui *progressUI
+			if progressEnabled(c.Bool("no-progress"), reportPath) {
+				logger = quietLogger{}
+				ui = newProgressUI(numWorkers)
+			}
+
 			// Worker function
 			worker := func(id int) {
 				defer wg.Done()
 				for path := range jobs {
+					start := time.Now()
+
 					// CACHE CHECK
 					if cached, _ := fileCache.Check(path); cached {
+						if report != nil {
+							report.Write(ReportEntry{Input: path, Action: "skipped-cached", DurationMS: time.Since(start).Milliseconds()})
+						}
 						continue
 					}
 
-					finalPath, err := remuxFile(path, cfg)
-					if err != nil {
-						fmt.Printf("Worker %d: Failed to process %s: %v\n", id, path, err)
-					} else {
+					if ui != nil {
+						ui.StartFile(id, path)
+					}
+
+					plan, err := remuxFile(path, cfg, isDryRun, outputMode, targetDir, logger, func(percent int) {
+						if ui != nil {
+							ui.SetFileProgress(id, percent)
+						}
+					})
+
+					if ui != nil {
+						ui.FinishFile(id)
+					}
+
+					entry := ReportEntry{Input: path, Plan: plan, DurationMS: time.Since(start).Milliseconds()}
+
+					switch {
+					case err != nil:
+						entry.Action = "error"
+						entry.Error = err.Error()
+						logger.Printf("Worker %d: Failed to process %s: %v\n", id, path, err)
+					case isDryRun:
+						if plan.NeedsRemux {
+							entry.Action = "planned"
+						} else {
+							entry.Action = "skipped-compliant"
+						}
+					default:
 						// Success (remuxed OR skipped as compliant)
-						if finalPath != "" {
-							if err := fileCache.Update(finalPath); err != nil {
-								fmt.Printf("Worker %d: Warning - Failed to update cache for %s: %v\n", id, finalPath, err)
+						if plan.NeedsRemux {
+							entry.Action = "remuxed"
+						} else {
+							entry.Action = "skipped-compliant"
+						}
+						if err := fileCache.Update(plan.OutputFile); err != nil {
+							logger.Printf("Worker %d: Warning - Failed to update cache for %s: %v\n", id, plan.OutputFile, err)
+						}
+						// In sidecar/directory output modes the original
+						// survives untouched at plan.InputFile, with a
+						// different fingerprint than plan.OutputFile; cache
+						// that too, or the walker reprocesses it forever.
+						if plan.KeepOriginal && plan.InputFile != plan.OutputFile {
+							if err := fileCache.Update(plan.InputFile); err != nil {
+								logger.Printf("Worker %d: Warning - Failed to update cache for %s: %v\n", id, plan.InputFile, err)
 							}
 						}
 					}
+
+					if report != nil {
+						if werr := report.Write(entry); werr != nil {
+							logger.Printf("Worker %d: Warning - failed to write report entry: %v\n", id, werr)
+						}
+					}
 				}
 			}
 
@@ -116,25 +235,65 @@ func main() {
 				go worker(i)
 			}
 
-			// Walk and send jobs
-			err = filepath.WalkDir(targetDir, func(path string, info os.DirEntry, err error) error {
+			// Handle interrupts. In watch mode this also tells the
+			// fsnotify loop below to stop.
+			sigChan := make(chan os.Signal, 1)
+			signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
+
+			var watcher *fsnotify.Watcher
+			var watchDone chan struct{}
+			if isWatch {
+				watcher, err = fsnotify.NewWatcher()
+				if err != nil {
+					return fmt.Errorf("failed to start watcher: %v", err)
+				}
+				defer watcher.Close()
+			}
+
+			// Walk and send jobs. The walker dispatches ReadDir calls
+			// across a pool of goroutines so remux workers can start on
+			// the first files found rather than waiting for a single
+			// pass over a large tree to finish.
+			walker := NewWalker(os.DirFS(targetDir), isRecursive, c.Int("walk-workers"))
+			err = walker.Walk(".", func(relPath string, d fs.DirEntry, err error) error {
 				if err != nil {
 					return err
 				}
-				if info.IsDir() {
-					if !isRecursive && path != targetDir {
-						return filepath.SkipDir
+				fullPath := filepath.Join(targetDir, relPath)
+
+				if d.IsDir() {
+					if !isRecursive && relPath != "." {
+						return fs.SkipDir
+					}
+					if isWatch {
+						if werr := watcher.Add(fullPath); werr != nil {
+							fmt.Printf("Warning: could not watch %s: %v\n", fullPath, werr)
+						}
 					}
 					return nil
 				}
 
-				if strings.ToLower(filepath.Ext(path)) == ".mkv" {
-					jobs <- path
+				if strings.ToLower(filepath.Ext(fullPath)) == ".mkv" {
+					jobs <- fullPath
+					if ui != nil {
+						ui.DiscoverFile()
+					}
 				}
 				return nil
 			})
+			if err != nil {
+				close(jobs)
+				return fmt.Errorf("error walking directory: %v", err)
+			}
+
+			if isWatch {
+				fmt.Printf("Watching %s for new .mkv files (Ctrl+C to stop)...\n", targetDir)
+				watchDone = make(chan struct{})
+				go runWatchLoop(watcher, jobs, isRecursive, ui, watchDone)
+			} else {
+				close(jobs) // Signal workers to finish
+			}
 
-			close(jobs) // Signal workers to finish
 			// Wait for workers or interrupt
 			done := make(chan struct{})
 			go func() {
@@ -142,24 +301,25 @@ func main() {
 				close(done)
 			}()
 
-			// Handle interrupts
-			sigChan := make(chan os.Signal, 1)
-			signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
-
 			select {
 			case <-done:
-				// Finished normally
+				// Finished normally (non-watch mode, all jobs drained)
 			case <-sigChan:
-				fmt.Println("\nInterrupt received. Stopping...")
-				// We can just exit, the defer will handle saving.
-				// But we should probably stop feeding jobs?
-				// For now, let's just break and let defer save.
+				fmt.Println("\nInterrupt received. Draining in-flight remuxes...")
+				if isWatch {
+					// In watch mode jobs is still open; runWatchLoop never
+					// closes it since it runs until we stop it here. In
+					// non-watch mode the walk above already closed it.
+					watcher.Close()
+					<-watchDone
+					close(jobs)
+				}
+				<-done
 			}
 
-			if err != nil {
-				return fmt.Errorf("error walking directory: %v", err)
+			if ui != nil {
+				ui.Stop()
 			}
-			// Saved by defer
 
 			fmt.Println("Batch processing complete.")
 			return nil
@@ -171,3 +331,88 @@ func main() {
 		os.Exit(1)
 	}
 }
+
+// runWatchLoop consumes fsnotify events for the target tree and enqueues
+// newly-appearing .mkv files once their size has settled. It adds watches
+// for newly created subdirectories when recursive mode is enabled, and
+// returns once the watcher is closed.
+func runWatchLoop(watcher *fsnotify.Watcher, jobs chan<- string, isRecursive bool, ui *progressUI, done chan<- struct{}) {
+	defer close(done)
+
+	var pending sync.WaitGroup
+	for {
+		select {
+		case event, ok := <-watcher.Events:
+			if !ok {
+				pending.Wait()
+				return
+			}
+
+			if event.Op&(fsnotify.Create|fsnotify.Rename|fsnotify.Chmod) == 0 {
+				continue
+			}
+
+			info, err := os.Stat(event.Name)
+			if err != nil {
+				continue
+			}
+
+			if info.IsDir() {
+				if isRecursive && event.Op&(fsnotify.Create|fsnotify.Rename) != 0 {
+					if err := watcher.Add(event.Name); err != nil {
+						fmt.Printf("Warning: could not watch %s: %v\n", event.Name, err)
+					}
+				}
+				continue
+			}
+
+			if strings.ToLower(filepath.Ext(event.Name)) != ".mkv" {
+				continue
+			}
+
+			pending.Add(1)
+			go func(path string) {
+				defer pending.Done()
+				if waitForStableSize(path) {
+					jobs <- path
+					if ui != nil {
+						ui.DiscoverFile()
+					}
+				}
+			}(event.Name)
+
+		case werr, ok := <-watcher.Errors:
+			if !ok {
+				pending.Wait()
+				return
+			}
+			fmt.Printf("Watcher error: %v\n", werr)
+		}
+	}
+}
+
+// waitForStableSize polls the file's size until it stops changing for
+// watchSettleDelay, which lets in-progress downloads/moves finish before
+// we hand the path to a worker. Returns false if the file disappears
+// before settling.
+func waitForStableSize(path string) bool {
+	var lastSize int64 = -1
+	stableSince := time.Now()
+
+	for {
+		info, err := os.Stat(path)
+		if err != nil {
+			return false
+		}
+
+		size := info.Size()
+		if size != lastSize {
+			lastSize = size
+			stableSince = time.Now()
+		} else if time.Since(stableSince) >= watchSettleDelay {
+			return true
+		}
+
+		time.Sleep(watchStatInterval)
+	}
+}
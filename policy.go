@@ -0,0 +1,219 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// languageAliases canonicalizes the BCP-47/ISO-639-2 tags mkvmerge
+// reports down to their ISO-639-2 form, so a policy written with "en"
+// matches a track tagged "eng" or "en-US". Unrecognized tags fall back
+// to their primary subtag in normalizeLanguage below.
+var languageAliases = map[string]string{
+	"en": "eng", "eng": "eng", "en-us": "eng", "en-gb": "eng",
+	"ja": "jpn", "jpn": "jpn",
+	"fr": "fre", "fra": "fre", "fre": "fre",
+	"de": "ger", "deu": "ger", "ger": "ger",
+	"es": "spa", "spa": "spa",
+	"it": "ita", "ita": "ita",
+	"pt": "por", "por": "por", "pt-br": "por",
+	"ru": "rus", "rus": "rus",
+	"zh": "chi", "zho": "chi", "chi": "chi",
+	"ko": "kor", "kor": "kor",
+	"und": "und",
+}
+
+// normalizeLanguage canonicalizes a language tag for comparison. Known
+// aliases map straight to their ISO-639-2 form; an unrecognized tag
+// with a region/script suffix ("xx-YY") falls back to its primary
+// subtag so at least the base language still compares correctly.
+func normalizeLanguage(tag string) string {
+	tag = strings.ToLower(strings.TrimSpace(tag))
+	if canon, ok := languageAliases[tag]; ok {
+		return canon
+	}
+	if i := strings.IndexAny(tag, "-_"); i >= 0 {
+		if canon, ok := languageAliases[tag[:i]]; ok {
+			return canon
+		}
+		return tag[:i]
+	}
+	return tag
+}
+
+func languagesEqual(a, b string) bool {
+	return normalizeLanguage(a) == normalizeLanguage(b)
+}
+
+// matchesLanguageList reports whether lang matches any entry in list,
+// aliases considered.
+func matchesLanguageList(lang string, list []string) bool {
+	for _, l := range list {
+		if languagesEqual(lang, l) {
+			return true
+		}
+	}
+	return false
+}
+
+// trackLanguage prefers a track's BCP-47 language_ietf tag, falling
+// back to its ISO-639-2 language field when language_ietf is absent.
+func trackLanguage(track Track) string {
+	if track.Properties.LanguageIETF != "" {
+		return track.Properties.LanguageIETF
+	}
+	return track.Properties.Language
+}
+
+// resolvePolicy returns cfg's Policy if it set one, or otherwise builds
+// one that reproduces cfg's flat language-list behavior, so configs
+// written before Policy existed keep working unmodified.
+func resolvePolicy(cfg *Config) *Policy {
+	if cfg.Policy != nil {
+		return cfg.Policy
+	}
+	return &Policy{
+		Video:     TrackRule{Languages: []string{cfg.VideoLanguage}},
+		Audio:     TrackRule{Languages: cfg.AudioLanguages, DefaultLanguage: cfg.DefaultAudio},
+		Subtitles: TrackRule{Languages: cfg.SubtitleLanguages},
+	}
+}
+
+// videoTargetLanguage returns the language tag the output's video track
+// should carry: the first language listed in rule, or "" if the rule
+// doesn't constrain video language at all.
+func videoTargetLanguage(rule TrackRule) string {
+	if len(rule.Languages) == 0 {
+		return ""
+	}
+	return rule.Languages[0]
+}
+
+// compileNameMatch compiles rule's name_match pattern once per
+// buildRemuxPlan call. An empty or invalid pattern yields a nil
+// *regexp.Regexp, which evaluateTrack treats as "no constraint".
+func compileNameMatch(pattern string) *regexp.Regexp {
+	if pattern == "" {
+		return nil
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil
+	}
+	return re
+}
+
+// evaluateTrack decides whether to keep track under rule, recording a
+// human-readable reason either way so dry-run reports can explain it.
+func evaluateTrack(track Track, rule TrackRule, nameRe *regexp.Regexp) TrackDecision {
+	decision := TrackDecision{TrackID: track.ID, Type: track.Type}
+	lang := trackLanguage(track)
+
+	if len(rule.Languages) > 0 && !matchesLanguageList(lang, rule.Languages) {
+		decision.Reason = fmt.Sprintf("language %q not in policy list %v", lang, rule.Languages)
+		return decision
+	}
+
+	if nameRe != nil && !nameRe.MatchString(track.Properties.Name) {
+		decision.Reason = fmt.Sprintf("track name %q does not match %q", track.Properties.Name, rule.NameMatch)
+		return decision
+	}
+
+	if rule.DropForced && track.Properties.ForcedTrack {
+		decision.Reason = "forced track dropped by policy"
+		return decision
+	}
+
+	if rule.DropHearingImpaired && track.Properties.FlagHearingImpaired {
+		decision.Reason = "hearing-impaired track dropped by policy"
+		return decision
+	}
+
+	decision.Kept = true
+	decision.Reason = "matches policy"
+	return decision
+}
+
+// looksLikeCommentary reports whether a track's name suggests a
+// commentary track, used by chooseDefaultAudio's PreferNonCommentary
+// tie-break.
+var commentaryPattern = regexp.MustCompile(`(?i)commentary`)
+
+func looksLikeCommentary(name string) bool {
+	return commentaryPattern.MatchString(name)
+}
+
+// chooseDefaultAudio picks which of the kept audio tracks should carry
+// the default flag: those matching rule.DefaultLanguage, tie-broken by
+// rule.PreferNonCommentary and rule.PreferMostChannels in that order.
+// Returns -1 if DefaultLanguage is unset or no kept track matches it.
+func chooseDefaultAudio(kept []Track, rule TrackRule) int {
+	if rule.DefaultLanguage == "" {
+		return -1
+	}
+
+	var candidates []Track
+	for _, t := range kept {
+		if languagesEqual(trackLanguage(t), rule.DefaultLanguage) {
+			candidates = append(candidates, t)
+		}
+	}
+	if len(candidates) == 0 {
+		return -1
+	}
+
+	best := candidates[0]
+	for _, t := range candidates[1:] {
+		if rule.PreferNonCommentary && looksLikeCommentary(best.Properties.Name) && !looksLikeCommentary(t.Properties.Name) {
+			best = t
+			continue
+		}
+		// A commentary track never overtakes a non-commentary one on
+		// channel count alone: PreferNonCommentary outranks
+		// PreferMostChannels, so only compare channels when neither
+		// candidate's commentary status would otherwise decide it.
+		if rule.PreferNonCommentary && looksLikeCommentary(t.Properties.Name) && !looksLikeCommentary(best.Properties.Name) {
+			continue
+		}
+		if rule.PreferMostChannels && t.Properties.AudioChannels > best.Properties.AudioChannels {
+			best = t
+			continue
+		}
+	}
+	return best.ID
+}
+
+// releaseTagPattern strips bracketed/parenthesized release-group noise
+// from a track's original name, e.g. "English (NF) [x264-GROUP]" ->
+// "English".
+var releaseTagPattern = regexp.MustCompile(`[\[\(][^\]\)]*[\]\)]`)
+
+// cleanTrackName strips release-group tags and collapses whitespace.
+func cleanTrackName(name string) string {
+	return strings.Join(strings.Fields(releaseTagPattern.ReplaceAllString(name, "")), " ")
+}
+
+// renderTrackName applies rule's RenameTemplate to track, substituting
+// {language}, {name} (the cleaned original title), {forced}, and {hi}
+// placeholders. An empty template leaves the track's name untouched.
+func renderTrackName(rule TrackRule, track Track) string {
+	if rule.RenameTemplate == "" {
+		return track.Properties.Name
+	}
+
+	forced, hi := "", ""
+	if track.Properties.ForcedTrack {
+		forced = "[Forced]"
+	}
+	if track.Properties.FlagHearingImpaired {
+		hi = "[HI]"
+	}
+
+	out := rule.RenameTemplate
+	out = strings.ReplaceAll(out, "{language}", normalizeLanguage(trackLanguage(track)))
+	out = strings.ReplaceAll(out, "{name}", cleanTrackName(track.Properties.Name))
+	out = strings.ReplaceAll(out, "{forced}", forced)
+	out = strings.ReplaceAll(out, "{hi}", hi)
+	return strings.Join(strings.Fields(out), " ")
+}
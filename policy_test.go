@@ -0,0 +1,118 @@
+package main
+
+import "testing"
+
+func TestNormalizeLanguageAliases(t *testing.T) {
+	cases := []struct{ a, b string }{
+		{"eng", "en"},
+		{"en", "en-US"},
+		{"eng", "en-GB"},
+		{"fre", "fr"},
+	}
+	for _, c := range cases {
+		if !languagesEqual(c.a, c.b) {
+			t.Errorf("expected %q and %q to be equal languages", c.a, c.b)
+		}
+	}
+
+	if languagesEqual("eng", "jpn") {
+		t.Error("expected eng and jpn to be different languages")
+	}
+}
+
+func TestEvaluateTrackLanguageAndNameMatch(t *testing.T) {
+	rule := TrackRule{Languages: []string{"eng"}, NameMatch: "Commentary"}
+	nameRe := compileNameMatch(rule.NameMatch)
+
+	kept := Track{ID: 1, Type: "audio", Properties: TrackProperties{Language: "eng", Name: "Director Commentary"}}
+	if d := evaluateTrack(kept, rule, nameRe); !d.Kept {
+		t.Errorf("expected track matching language and name_match to be kept, got reason %q", d.Reason)
+	}
+
+	wrongLang := Track{ID: 2, Type: "audio", Properties: TrackProperties{Language: "jpn", Name: "Commentary"}}
+	if d := evaluateTrack(wrongLang, rule, nameRe); d.Kept {
+		t.Error("expected track with non-matching language to be dropped")
+	}
+
+	noNameMatch := Track{ID: 3, Type: "audio", Properties: TrackProperties{Language: "eng", Name: "Main Audio"}}
+	if d := evaluateTrack(noNameMatch, rule, nameRe); d.Kept {
+		t.Error("expected track not matching name_match regex to be dropped")
+	}
+}
+
+func TestEvaluateTrackDropsForcedAndHearingImpaired(t *testing.T) {
+	rule := TrackRule{DropForced: true, DropHearingImpaired: true}
+
+	forced := Track{Properties: TrackProperties{ForcedTrack: true}}
+	if d := evaluateTrack(forced, rule, nil); d.Kept {
+		t.Error("expected forced track to be dropped when DropForced is set")
+	}
+
+	hi := Track{Properties: TrackProperties{FlagHearingImpaired: true}}
+	if d := evaluateTrack(hi, rule, nil); d.Kept {
+		t.Error("expected hearing-impaired track to be dropped when DropHearingImpaired is set")
+	}
+
+	plain := Track{Properties: TrackProperties{}}
+	if d := evaluateTrack(plain, rule, nil); !d.Kept {
+		t.Errorf("expected plain track to be kept, got reason %q", d.Reason)
+	}
+}
+
+func TestChooseDefaultAudioPrefersNonCommentaryOverChannelCount(t *testing.T) {
+	rule := TrackRule{DefaultLanguage: "eng", PreferNonCommentary: true, PreferMostChannels: true}
+
+	candidates := []Track{
+		{ID: 1, Properties: TrackProperties{Language: "eng", Name: "English", AudioChannels: 2}},
+		{ID: 2, Properties: TrackProperties{Language: "eng", Name: "English", AudioChannels: 6}},
+		{ID: 3, Properties: TrackProperties{Language: "eng", Name: "Commentary", AudioChannels: 8}},
+	}
+
+	got := chooseDefaultAudio(candidates, rule)
+	if got != 2 {
+		t.Errorf("expected track 2 (highest-channel non-commentary) to be chosen, got %d", got)
+	}
+}
+
+func TestChooseDefaultAudioPrefersMostChannelsAmongNonCommentary(t *testing.T) {
+	rule := TrackRule{DefaultLanguage: "eng", PreferMostChannels: true}
+
+	candidates := []Track{
+		{ID: 1, Properties: TrackProperties{Language: "eng", AudioChannels: 2}},
+		{ID: 2, Properties: TrackProperties{Language: "eng", AudioChannels: 6}},
+	}
+
+	if got := chooseDefaultAudio(candidates, rule); got != 2 {
+		t.Errorf("expected track 2 (more channels) to be chosen, got %d", got)
+	}
+}
+
+func TestChooseDefaultAudioNoDefaultLanguage(t *testing.T) {
+	rule := TrackRule{}
+	candidates := []Track{{ID: 1, Properties: TrackProperties{Language: "eng"}}}
+	if got := chooseDefaultAudio(candidates, rule); got != -1 {
+		t.Errorf("expected -1 when DefaultLanguage is unset, got %d", got)
+	}
+}
+
+func TestRenderTrackNameTemplate(t *testing.T) {
+	rule := TrackRule{RenameTemplate: "{language} {forced}"}
+	track := Track{Properties: TrackProperties{
+		Language:    "eng",
+		Name:        "English (NF) [x264-GROUP]",
+		ForcedTrack: true,
+	}}
+
+	got := renderTrackName(rule, track)
+	want := "eng [Forced]"
+	if got != want {
+		t.Errorf("renderTrackName() = %q, want %q", got, want)
+	}
+}
+
+func TestRenderTrackNameNoTemplateLeavesNameUnchanged(t *testing.T) {
+	track := Track{Properties: TrackProperties{Name: "English"}}
+	if got := renderTrackName(TrackRule{}, track); got != "English" {
+		t.Errorf("expected unchanged name, got %q", got)
+	}
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"sync"
+
+	"github.com/vbauerster/mpb/v8"
+	"github.com/vbauerster/mpb/v8/decor"
+	"golang.org/x/term"
+)
+
+// Logger is the small abstraction worker/remux status lines print
+// through, so routing them away from stdout when progress bars own it
+// doesn't mean scattering progress-mode checks through the whole package.
+type Logger interface {
+	Printf(format string, args ...interface{})
+}
+
+// stdLogger prints straight to stdout; used when the progress UI is off.
+type stdLogger struct{}
+
+func (stdLogger) Printf(format string, args ...interface{}) {
+	fmt.Printf(format, args...)
+}
+
+// quietLogger prints to stderr, keeping stdout free for the progress
+// bars to redraw without interference.
+type quietLogger struct{}
+
+func (quietLogger) Printf(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, format, args...)
+}
+
+// progressEnabled decides whether the mpb progress UI should be used:
+// stdout must be a terminal, --no-progress must not be set, and the
+// NDJSON report (if any) must not itself be streaming to stdout.
+func progressEnabled(noProgress bool, reportPath string) bool {
+	if noProgress || reportPath == "-" {
+		return false
+	}
+	return term.IsTerminal(int(os.Stdout.Fd()))
+}
+
+// guiProgressPattern matches mkvmerge's --gui-mode progress lines, e.g.
+// "#GUI#progress 42%".
+var guiProgressPattern = regexp.MustCompile(`#GUI#progress\s+(\d+)%`)
+
+// watchMkvmergeProgress scans r for mkvmerge --gui-mode progress lines
+// and reports each percentage via report. It must be drained even when
+// report is nil so the subprocess's stdout pipe never fills up.
+func watchMkvmergeProgress(r io.Reader, report func(percent int)) {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		if report == nil {
+			continue
+		}
+		m := guiProgressPattern.FindStringSubmatch(scanner.Text())
+		if m == nil {
+			continue
+		}
+		pct, err := strconv.Atoi(m[1])
+		if err != nil {
+			continue
+		}
+		report(pct)
+	}
+}
+
+// workerBar is a single persistent bar reused across every file a worker
+// processes. Its label is backed by a pointer so it can be relabeled
+// in place between files.
+type workerBar struct {
+	bar   *mpb.Bar
+	label string
+}
+
+// progressUI owns the mpb container: one persistent bar per worker
+// showing the current file and elapsed time, plus an overall bar
+// tracking files completed against files discovered so far.
+type progressUI struct {
+	p       *mpb.Progress
+	overall *mpb.Bar
+	workers []*workerBar
+
+	mu         sync.Mutex
+	discovered int
+}
+
+// newProgressUI creates the container and pre-allocates one bar per
+// worker plus the overall bar.
+func newProgressUI(numWorkers int) *progressUI {
+	ui := &progressUI{p: mpb.New(mpb.WithWidth(50))}
+
+	ui.overall = ui.p.AddBar(0,
+		mpb.PrependDecorators(decor.Name("overall", decor.WC{W: 10})),
+		mpb.AppendDecorators(decor.CountersNoUnit("%d / %d files")),
+	)
+
+	ui.workers = make([]*workerBar, numWorkers)
+	for i := range ui.workers {
+		wb := &workerBar{label: fmt.Sprintf("worker %d: idle", i)}
+		wb.bar = ui.p.AddBar(100,
+			mpb.PrependDecorators(decor.Any(func(decor.Statistics) string { return wb.label }, decor.WC{W: 34})),
+			mpb.AppendDecorators(decor.Elapsed(decor.ET_STYLE_GO)),
+		)
+		ui.workers[i] = wb
+	}
+
+	return ui
+}
+
+// DiscoverFile bumps the overall bar's total as new .mkv files are found
+// by the walker (or the watch-mode fsnotify loop).
+func (ui *progressUI) DiscoverFile() {
+	ui.mu.Lock()
+	ui.discovered++
+	total := ui.discovered
+	ui.mu.Unlock()
+	ui.overall.SetTotal(int64(total), false)
+}
+
+// StartFile relabels worker id's bar with the (truncated) filename being
+// processed and resets its progress.
+func (ui *progressUI) StartFile(id int, path string) {
+	wb := ui.workers[id]
+	wb.label = fmt.Sprintf("worker %d: %s", id, truncateName(path, 24))
+	wb.bar.SetCurrent(0)
+}
+
+// SetFileProgress updates worker id's bar to the given mkvmerge
+// completion percentage.
+func (ui *progressUI) SetFileProgress(id int, percent int) {
+	ui.workers[id].bar.SetCurrent(int64(percent))
+}
+
+// FinishFile relabels worker id's bar back to idle and increments the
+// overall bar. No separate line is printed or kept for the finished
+// file; only the overall bar's count records it.
+func (ui *progressUI) FinishFile(id int) {
+	wb := ui.workers[id]
+	wb.bar.SetCurrent(100)
+	wb.label = fmt.Sprintf("worker %d: idle", id)
+	ui.overall.Increment()
+}
+
+// Stop finalizes every bar and waits for the renderer to flush. Call it
+// once, after every job has been processed.
+func (ui *progressUI) Stop() {
+	for _, wb := range ui.workers {
+		wb.bar.Abort(true)
+	}
+	ui.overall.SetTotal(ui.overall.Current(), true)
+	ui.p.Wait()
+}
+
+// truncateName shortens a path to its base name, truncated to max
+// characters, for display in a fixed-width bar label.
+func truncateName(path string, max int) string {
+	name := filepath.Base(path)
+	if len(name) <= max {
+		return name
+	}
+	return name[:max-3] + "..."
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+)
+
+// reportWriter streams NDJSON ReportEntry records to a file, one per
+// line, safe for concurrent use by the worker pool.
+type reportWriter struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+	f   *os.File
+}
+
+// newReportWriter creates (or truncates) the file at path and returns a
+// writer ready for concurrent use. path of "-" streams to stdout
+// instead of a file, matching progressEnabled's treatment of "-" as
+// "the report already owns stdout".
+func newReportWriter(path string) (*reportWriter, error) {
+	if path == "-" {
+		return &reportWriter{enc: json.NewEncoder(os.Stdout)}, nil
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	return &reportWriter{enc: json.NewEncoder(f), f: f}, nil
+}
+
+// Write appends one NDJSON record.
+func (r *reportWriter) Write(entry ReportEntry) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.enc.Encode(entry)
+}
+
+// Close flushes and closes the underlying file. It's a no-op when
+// streaming to stdout, since we don't own that file.
+func (r *reportWriter) Close() error {
+	if r.f == nil {
+		return nil
+	}
+	return r.f.Close()
+}
@@ -1,17 +1,68 @@
 package main
 
-// Config holds the user preferences
+// Config holds the user preferences. Policy, when set, supersedes the
+// flat language lists below with the richer per-track-type rules it
+// describes; resolvePolicy derives an equivalent Policy from the flat
+// fields when Policy is nil, so existing config.json files keep working
+// unmodified.
 type Config struct {
 	VideoLanguage     string   `json:"video_language"`
 	AudioLanguages    []string `json:"audio_languages"`
 	DefaultAudio      string   `json:"default_audio_language"`
 	SubtitleLanguages []string `json:"subtitle_languages"`
+	Policy            *Policy  `json:"policy,omitempty"`
+}
+
+// Policy is the track-selection engine for one config: per-track-type
+// rules covering language matching, track-name regexes, forced/
+// hearing-impaired handling, which language becomes the default audio
+// track, and how kept tracks get renamed.
+type Policy struct {
+	Video     TrackRule `json:"video"`
+	Audio     TrackRule `json:"audio"`
+	Subtitles TrackRule `json:"subtitles"`
+}
+
+// TrackRule governs how one track type is evaluated. Languages is
+// matched with BCP-47/ISO-639-2 aliasing (see normalizeLanguage), so
+// "eng", "en", and "en-US" are equivalent; an empty Languages list
+// accepts any language. NameMatch, if set, is a regex that track_name
+// must also match. DefaultLanguage/PreferNonCommentary/
+// PreferMostChannels only apply to audio, where more than one kept
+// track may share a language. RenameTemplate rewrites the kept track's
+// name; see renderTrackName for its placeholders.
+type TrackRule struct {
+	Languages           []string `json:"languages,omitempty"`
+	NameMatch           string   `json:"name_match,omitempty"`
+	DropForced          bool     `json:"drop_forced,omitempty"`
+	DropHearingImpaired bool     `json:"drop_hearing_impaired,omitempty"`
+	DefaultLanguage     string   `json:"default_language,omitempty"`
+	PreferNonCommentary bool     `json:"prefer_non_commentary,omitempty"`
+	PreferMostChannels  bool     `json:"prefer_most_channels,omitempty"`
+	RenameTemplate      string   `json:"rename_template,omitempty"`
+}
+
+// TrackDecision explains what evaluateTrack decided for a single track
+// and why, so a dry-run report can show the reasoning behind every
+// keep, drop, or reflag.
+type TrackDecision struct {
+	TrackID    int    `json:"track_id"`
+	Type       string `json:"type"`
+	Kept       bool   `json:"kept"`
+	Reason     string `json:"reason"`
+	NewDefault *bool  `json:"new_default,omitempty"`
+	NewName    string `json:"new_name,omitempty"`
 }
 
 // Structures for parsing mkvmerge JSON
 type TrackProperties struct {
-	Language     string `json:"language"`
-	DefaultTrack bool   `json:"default_track"`
+	Language            string `json:"language"`
+	LanguageIETF        string `json:"language_ietf"`
+	Name                string `json:"track_name"`
+	DefaultTrack        bool   `json:"default_track"`
+	ForcedTrack         bool   `json:"forced_track"`
+	FlagHearingImpaired bool   `json:"flag_hearing_impaired"`
+	AudioChannels       int    `json:"audio_channels"`
 }
 
 type Track struct {
@@ -23,3 +74,47 @@ type Track struct {
 type MkvInfo struct {
 	Tracks []Track `json:"tracks"`
 }
+
+// RemuxPlan describes what remuxFile decided to do (or would do, in
+// dry-run mode) for a single file: which tracks to keep or drop, any
+// language or default-flag changes, and the resulting output path.
+type RemuxPlan struct {
+	InputFile             string          `json:"input_file"`
+	OutputFile            string          `json:"output_file"`
+	NeedsRemux            bool            `json:"needs_remux"`
+	KeepOriginal          bool            `json:"keep_original"`
+	KeptAudioTracks       []int           `json:"kept_audio_tracks,omitempty"`
+	DroppedAudioTracks    []int           `json:"dropped_audio_tracks,omitempty"`
+	KeptSubtitleTracks    []int           `json:"kept_subtitle_tracks,omitempty"`
+	DroppedSubtitleTracks []int           `json:"dropped_subtitle_tracks,omitempty"`
+	LanguageChanges       map[int]string  `json:"language_changes,omitempty"`
+	DefaultFlagChanges    map[int]bool    `json:"default_flag_changes,omitempty"`
+	RenameTracks          map[int]string  `json:"rename_tracks,omitempty"`
+	DefaultAudioTrackID   *int            `json:"default_audio_track_id,omitempty"`
+	TrackDecisions        []TrackDecision `json:"track_decisions,omitempty"`
+}
+
+// OutputMode selects how remuxed output is written: in place (replace,
+// the default), alongside the original (sidecar), or mirrored into a
+// separate directory tree (directory).
+type OutputMode struct {
+	Mode          string
+	DirectoryRoot string
+}
+
+const (
+	OutputModeReplace   = "replace"
+	OutputModeSidecar   = "sidecar"
+	OutputModeDirectory = "directory"
+)
+
+// ReportEntry is one line of the --report NDJSON stream: what was
+// attempted for a single file, the plan computed for it, and how long it
+// took.
+type ReportEntry struct {
+	Input      string     `json:"input"`
+	Action     string     `json:"action"`
+	Plan       *RemuxPlan `json:"plan,omitempty"`
+	DurationMS int64      `json:"duration_ms"`
+	Error      string     `json:"error,omitempty"`
+}
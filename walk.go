@@ -0,0 +1,174 @@
+package main
+
+import (
+	"io/fs"
+	"path"
+	"runtime"
+	"sync"
+)
+
+// Walker performs a bounded-concurrency recursive walk over an fs.FS,
+// dispatching the per-directory ReadDir calls across a pool of
+// goroutines instead of walking depth-first on a single one, so a
+// consumer reading matched files off a channel can start working on the
+// first files found instead of waiting for a full pass over a large
+// tree. It's modeled on the stepwise, queue-driven design of
+// github.com/kr/fs's Walker.
+//
+// Walk follows the same callback contract as fs.WalkDir: returning
+// fs.SkipDir from a directory's call skips that directory's contents,
+// returning it from a file's call skips the rest of that file's
+// directory, and any other non-nil error aborts the walk. Entries within
+// a single directory are always delivered in the sorted order fs.ReadDir
+// returns them in, but sibling directories are visited concurrently, so
+// fn may be called from multiple goroutines at once.
+type Walker struct {
+	fsys       fs.FS
+	recursive  bool
+	numWorkers int
+}
+
+// NewWalker creates a Walker over fsys. numWorkers <= 0 defaults to
+// runtime.NumCPU().
+func NewWalker(fsys fs.FS, recursive bool, numWorkers int) *Walker {
+	if numWorkers < 1 {
+		numWorkers = runtime.NumCPU()
+	}
+	return &Walker{fsys: fsys, recursive: recursive, numWorkers: numWorkers}
+}
+
+// walkJob is one directory queued for a ReadDir, paired with the
+// DirEntry that describes it (needed to report a ReadDir error back
+// through fn per the fs.WalkDirFunc contract).
+type walkJob struct {
+	path  string
+	entry fs.DirEntry
+}
+
+// Walk visits root and its direct entries and, when w.recursive is set,
+// every descendant below them, calling fn for each entry found.
+func (w *Walker) Walk(root string, fn fs.WalkDirFunc) error {
+	info, err := fs.Stat(w.fsys, root)
+	if err != nil {
+		return fn(root, nil, err)
+	}
+	rootEntry := fs.FileInfoToDirEntry(info)
+
+	if err := fn(root, rootEntry, nil); err != nil {
+		if err == fs.SkipDir {
+			return nil
+		}
+		return err
+	}
+	if !rootEntry.IsDir() {
+		return nil
+	}
+
+	// queue is an unbounded FIFO guarded by mu/cond rather than a
+	// buffered channel: processDir (running on a worker) is both the
+	// producer and the consumer of this queue, so a bounded channel can
+	// deadlock outright once every worker is simultaneously blocked
+	// trying to enqueue a full directory's worth of subdirectories with
+	// no goroutine left free to drain it. Appending to a slice under a
+	// mutex never blocks the producer side.
+	var (
+		mu      sync.Mutex
+		cond    = sync.NewCond(&mu)
+		queue   []walkJob
+		pending int
+		done    bool
+	)
+
+	var errOnce sync.Once
+	var walkErr error
+	var aborted bool
+	setErr := func(err error) {
+		errOnce.Do(func() { walkErr = err })
+		mu.Lock()
+		aborted = true
+		mu.Unlock()
+	}
+
+	enqueue := func(j walkJob) {
+		mu.Lock()
+		pending++
+		queue = append(queue, j)
+		mu.Unlock()
+		cond.Signal()
+	}
+	taskDone := func() {
+		mu.Lock()
+		pending--
+		if pending == 0 {
+			done = true
+			cond.Broadcast()
+		}
+		mu.Unlock()
+	}
+
+	enqueue(walkJob{path: root, entry: rootEntry})
+
+	var pool sync.WaitGroup
+	for i := 0; i < w.numWorkers; i++ {
+		pool.Add(1)
+		go func() {
+			defer pool.Done()
+			for {
+				mu.Lock()
+				for len(queue) == 0 && !done {
+					cond.Wait()
+				}
+				if len(queue) == 0 {
+					mu.Unlock()
+					return
+				}
+				j := queue[0]
+				queue = queue[1:]
+				skip := aborted
+				mu.Unlock()
+
+				// Once fn has aborted the walk, drain the queue without
+				// reading any further directories.
+				if !skip {
+					w.processDir(j, fn, enqueue, setErr)
+				}
+				taskDone()
+			}
+		}()
+	}
+
+	pool.Wait()
+	return walkErr
+}
+
+// processDir reads one directory's entries, invokes fn for each, and
+// queues any subdirectories for later processing when recursive.
+func (w *Walker) processDir(dir walkJob, fn fs.WalkDirFunc, enqueue func(walkJob), setErr func(error)) {
+	entries, err := fs.ReadDir(w.fsys, dir.path)
+	if err != nil {
+		if cbErr := fn(dir.path, dir.entry, err); cbErr != nil && cbErr != fs.SkipDir {
+			setErr(cbErr)
+		}
+		return
+	}
+
+	for _, entry := range entries {
+		p := path.Join(dir.path, entry.Name())
+
+		cbErr := fn(p, entry, nil)
+		if cbErr != nil {
+			if cbErr == fs.SkipDir {
+				if entry.IsDir() {
+					continue
+				}
+				return
+			}
+			setErr(cbErr)
+			return
+		}
+
+		if entry.IsDir() && w.recursive {
+			enqueue(walkJob{path: p, entry: entry})
+		}
+	}
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"sort"
+	"sync"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func TestWalkerParityWithFSWalkDir(t *testing.T) {
+	mapFS := fstest.MapFS{
+		"a.mkv":            {Data: []byte("x")},
+		"b.txt":            {Data: []byte("x")},
+		"sub/c.mkv":        {Data: []byte("x")},
+		"sub/d.txt":        {Data: []byte("x")},
+		"sub/nested/e.mkv": {Data: []byte("x")},
+		"sub2/f.mkv":       {Data: []byte("x")},
+	}
+
+	var want []string
+	if err := fs.WalkDir(mapFS, ".", func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		want = append(want, path)
+		return nil
+	}); err != nil {
+		t.Fatalf("fs.WalkDir: %v", err)
+	}
+
+	var (
+		mu  sync.Mutex
+		got []string
+	)
+	w := NewWalker(mapFS, true, 4)
+	if err := w.Walk(".", func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		mu.Lock()
+		got = append(got, path)
+		mu.Unlock()
+		return nil
+	}); err != nil {
+		t.Fatalf("Walker.Walk: %v", err)
+	}
+
+	sort.Strings(want)
+	sort.Strings(got)
+
+	if len(want) != len(got) {
+		t.Fatalf("entry count mismatch: fs.WalkDir found %d, Walker found %d", len(want), len(got))
+	}
+	for i := range want {
+		if want[i] != got[i] {
+			t.Fatalf("entry mismatch at %d: fs.WalkDir=%q Walker=%q", i, want[i], got[i])
+		}
+	}
+}
+
+func TestWalkerNonRecursiveSkipsSubdirectories(t *testing.T) {
+	mapFS := fstest.MapFS{
+		"a.mkv":     {Data: []byte("x")},
+		"sub/b.mkv": {Data: []byte("x")},
+	}
+
+	var (
+		mu  sync.Mutex
+		got []string
+	)
+	w := NewWalker(mapFS, false, 2)
+	if err := w.Walk(".", func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		mu.Lock()
+		got = append(got, path)
+		mu.Unlock()
+		return nil
+	}); err != nil {
+		t.Fatalf("Walker.Walk: %v", err)
+	}
+
+	for _, p := range got {
+		if p == "sub/b.mkv" {
+			t.Fatalf("non-recursive walk should not have visited %q", p)
+		}
+	}
+}
+
+func TestWalkerRespectsSkipDir(t *testing.T) {
+	mapFS := fstest.MapFS{
+		"keep/a.mkv":   {Data: []byte("x")},
+		"skip/b.mkv":   {Data: []byte("x")},
+		"skip/c/d.mkv": {Data: []byte("x")},
+	}
+
+	var (
+		mu  sync.Mutex
+		got []string
+	)
+	w := NewWalker(mapFS, true, 2)
+	if err := w.Walk(".", func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() && path == "skip" {
+			return fs.SkipDir
+		}
+		mu.Lock()
+		got = append(got, path)
+		mu.Unlock()
+		return nil
+	}); err != nil {
+		t.Fatalf("Walker.Walk: %v", err)
+	}
+
+	for _, p := range got {
+		if p == "skip/b.mkv" || p == "skip/c/d.mkv" || p == "skip/c" {
+			t.Fatalf("fs.SkipDir should have pruned %q", p)
+		}
+	}
+}
+
+// TestWalkerHighFanOutDoesNotDeadlock guards against the queue filling
+// up with more pending subdirectories than numWorkers can ever drain: a
+// handful of directories each with thousands of children is exactly the
+// topology that deadlocked a bounded producer/consumer channel.
+func TestWalkerHighFanOutDoesNotDeadlock(t *testing.T) {
+	const dirs, subdirsPerDir = 4, 1000
+
+	mapFS := fstest.MapFS{}
+	for i := 0; i < dirs; i++ {
+		for j := 0; j < subdirsPerDir; j++ {
+			mapFS[fmt.Sprintf("dir%d/sub%d/leaf.mkv", i, j)] = &fstest.MapFile{Data: []byte("x")}
+		}
+	}
+
+	w := NewWalker(mapFS, true, 4)
+
+	result := make(chan error, 1)
+	go func() {
+		result <- w.Walk(".", func(path string, d fs.DirEntry, err error) error {
+			return err
+		})
+	}()
+
+	select {
+	case err := <-result:
+		if err != nil {
+			t.Fatalf("Walker.Walk: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Walker.Walk deadlocked on a high-fan-out tree")
+	}
+}